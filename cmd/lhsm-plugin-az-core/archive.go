@@ -1,33 +1,64 @@
 package lhsm_plugin_az_core
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"net/url"
 	"os"
 	"path"
 	"strings"
-	"syscall"
 
-	"github.com/Azure/azure-pipeline-go/pipeline"
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/directory"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/file"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/filesystem"
+	dlservice "github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/service"
 	"github.com/wastore/lemur/cmd/util"
 )
 
 type ArchiveOptions struct {
-	AccountName   string
-	ContainerName string
-	ResourceSAS   string
-	MountRoot     string
-	BlobName      string
-	SourcePath    string
-	Credential    azblob.Credential
-	Parallelism   uint16
-	BlockSize     int64
-	Pacer         util.Pacer
-	ExportPrefix  string
-	HNSEnabled    bool
+	// ServiceClient and, on HNS accounts, DatalakeClient are built once by
+	// the caller (via NewServiceClient/NewDatalakeServiceClient) and reused
+	// across archive requests for the same account.
+	ServiceClient  *azblob.Client
+	DatalakeClient *dlservice.Client
+	ContainerName  string
+	MountRoot      string
+	BlobName       string
+	SourcePath     string
+	Parallelism    uint16
+	BlockSize      int64
+	Pacer          util.Pacer
+	ExportPrefix   string
+	HNSEnabled     bool
+	// AccessTier sets the blob access tier at upload time. The zero value
+	// leaves the blob on the account's default tier.
+	AccessTier blob.AccessTier
+	// CPKInfo and CPKScopeInfo encrypt the uploaded content with a
+	// customer-provided key or encryption scope, respectively. At most one
+	// is normally set; see ResolveCPK.
+	CPKInfo      *blob.CPKInfo
+	CPKScopeInfo *blob.CPKScopeInfo
+
+	// PlanDir is where the checkpointed block-staging plan for this file
+	// is kept. Defaults to jobs.DefaultPlanDir.
+	PlanDir string
+	// JobID identifies the plan file on disk. Defaults to a URL-safe base64
+	// encoding of ContainerName and BlobName, so a retry of the same archive request
+	// resumes the same plan rather than starting a fresh one.
+	JobID string
+	// Fid is the caller's identifier for the source file (e.g. a Lustre
+	// FID rendered as a string), recorded in the plan purely for operator
+	// visibility via `lhsmd jobs show`.
+	Fid string
+
+	// Tags are blob index tags set from HSM policy (e.g. archiveID,
+	// fsname, originalPath, uid, gid, retentionClass), queryable later via
+	// FindBlobsByTags and used by RemoveOptions/RestoreOptions' tag
+	// filters. They ride along on CommitBlockList when possible; see
+	// commitPlan for the CPK fallback.
+	Tags map[string]string
 }
 
 // persist a blob to the local filesystem
@@ -36,73 +67,85 @@ func Archive(o ArchiveOptions) (int64, error) {
 	ctx, cancel := context.WithCancel(archiveCtx)
 	defer cancel()
 
-	p := util.NewPipeline(ctx, o.Credential, o.Pacer, azblob.PipelineOptions{})
+	containerClient := o.ServiceClient.ServiceClient().NewContainerClient(o.ContainerName)
 
-	//Get the blob URL
-	cURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s%s", o.AccountName, o.ContainerName, o.ResourceSAS))
-	containerURL := azblob.NewContainerURL(*cURL, p)
-	blobURL := containerURL.NewBlockBlobURL(o.BlobName)
+	util.Log(util.LogInfo, fmt.Sprintf("Archiving %s", o.BlobName))
 
-	util.Log(pipeline.LogInfo, fmt.Sprintf("Archiving %s", o.BlobName))
+	var fsClient *filesystem.Client
+	if o.HNSEnabled {
+		fsClient = o.DatalakeClient.NewFileSystemClient(o.ContainerName)
+	}
 
 	//1. Upload and perserve permissions and acl for parents
 	parents := strings.Split(o.BlobName, string(os.PathSeparator))
 	parents = parents[:len(parents)-1]
-	u := cURL
+	blobPath := ""
 	dirPath := o.MountRoot
 
 	for _, currDir := range parents {
 		var acl string
-		u.Path = path.Join(u.Path, currDir) //keep appending path to the url
-		dirURL := azblob.NewBlockBlobURL(*u, p)
-		meta := azblob.Metadata{}
+		blobPath = path.Join(blobPath, currDir)
+		dirURL := containerClient.NewBlockBlobClient(blobPath)
 
 		//Get owner, group and perms
 		dirPath = path.Join(dirPath, currDir)
 		dir, err := os.Open(dirPath)
 		if err != nil {
-			util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
 			return 0, err
 		}
 		defer dir.Close()
 		dirInfo, err := dir.Stat()
 		if err != nil {
-			util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
+			return 0, err
+		}
+
+		posixMeta, err := capturePosixMetadata(dirPath, dirInfo)
+		if err != nil {
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to capture POSIX metadata: %s", o.BlobName, err.Error()))
+			return 0, err
+		}
+		xattrs, err := listXattrs(dirPath)
+		if err != nil {
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to list xattrs: %s", o.BlobName, err.Error()))
 			return 0, err
 		}
-		owner := fmt.Sprintf("%d", dirInfo.Sys().(*syscall.Stat_t).Uid)
-		permissions := fmt.Sprintf("%o", dirInfo.Mode())
-		group := fmt.Sprintf("%d", dirInfo.Sys().(*syscall.Stat_t).Gid)
-		modTime := dirInfo.ModTime().Format("2006-01-02 15:04:05 -0700")
 
 		if o.HNSEnabled {
-			aclResp, err := dirURL.GetAccessControl(ctx, nil, nil, nil, nil, nil, nil, nil, nil)
-			if stgErr, ok := err.(azblob.StorageError); err != nil || ok && stgErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
-				util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
+			aclResp, err := fsClient.NewDirectoryClient(blobPath).GetAccessControl(ctx, nil)
+			if err != nil {
+				util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
 				return 0, err
 			}
-			acl = aclResp.XMsACL()
+			acl = *aclResp.ACL
 		}
 
-		meta["hdi_isfolder"] = "true"
-		if !o.HNSEnabled {
-			meta["Permissions"] = permissions
-			meta["ModTime"] = modTime
-			meta["Owner"] = owner
-			meta["Group"] = group
+		meta, err := posixBlobMetadata(posixMeta, xattrs)
+		if err != nil {
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to build POSIX metadata: %s", o.BlobName, err.Error()))
+			return 0, err
 		}
+		meta["hdi_isfolder"] = toPtr("true")
 
-		_, err = dirURL.Upload(ctx, bytes.NewReader(nil), azblob.BlobHTTPHeaders{}, meta, azblob.BlobAccessConditions{}, azblob.AccessTierNone, azblob.BlobTagsMap{}, azblob.ClientProvidedKeyOptions{})
-
+		uploadOpts := &blockblob.UploadOptions{
+			Metadata:     meta,
+			CPKInfo:      o.CPKInfo,
+			CPKScopeInfo: o.CPKScopeInfo,
+		}
+		if o.AccessTier != "" {
+			uploadOpts.Tier = &o.AccessTier
+		}
+		_, err = dirURL.Upload(ctx, nil, uploadOpts)
 		if err != nil {
-			util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to upload directory: %s", u.Path, err.Error()))
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to upload directory: %s", blobPath, err.Error()))
 			return 0, err
 		}
 
 		if o.HNSEnabled {
-			_, err := dirURL.SetAccessControl(ctx, nil, nil, nil, nil, nil, &acl, nil, nil, nil, nil, nil)
+			_, err := fsClient.NewDirectoryClient(blobPath).SetAccessControl(ctx, &directory.SetAccessControlOptions{ACL: &acl})
 			if err != nil {
-				util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", u.Path, err.Error()))
+				util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", blobPath, err.Error()))
 				return 0, err
 			}
 		}
@@ -110,66 +153,63 @@ func Archive(o ArchiveOptions) (int64, error) {
 
 	// 2. Upload the file
 	// open the file to read from
-	file, _ := os.Open(o.SourcePath)
-	fileInfo, _ := file.Stat()
-	defer file.Close()
+	srcFile, err := os.Open(o.SourcePath)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to open source file: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+	defer srcFile.Close()
+	fileInfo, err := srcFile.Stat()
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to stat source file: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
 
 	//Save owner, perm, group and acl info
 	total := fileInfo.Size()
-	meta := azblob.Metadata{}
-	owner := fmt.Sprintf("%d", fileInfo.Sys().(*syscall.Stat_t).Uid)
-	permissions := fmt.Sprintf("%o", fileInfo.Mode())
-	group := fmt.Sprintf("%d", fileInfo.Sys().(*syscall.Stat_t).Gid)
-	modTime := fileInfo.ModTime().Format("2006-01-02 15:04:05 -0700")
+	posixMeta, err := capturePosixMetadata(o.SourcePath, fileInfo)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to capture POSIX metadata: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+	xattrs, err := listXattrs(o.SourcePath)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to list xattrs: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+	meta, err := posixBlobMetadata(posixMeta, xattrs)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to build POSIX metadata: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
 	var acl string
 
-	meta["Permissions"] = permissions
-	meta["ModTime"] = modTime
-	meta["Owner"] = owner
-	meta["Group"] = group
-
 	if o.HNSEnabled {
-		u, _ := url.Parse(fmt.Sprintf("https://%s.dfs.core.windows.net/%s/%s%s", o.AccountName, o.ContainerName, o.BlobName, o.ResourceSAS))
-		dfsURL := azblob.NewBlockBlobURL(*u, p)
-		aclResp, err := dfsURL.GetAccessControl(ctx, nil, nil, nil, nil, nil, nil, nil, nil)
+		aclResp, err := fsClient.NewFileClient(o.BlobName).GetAccessControl(ctx, nil)
 		if err != nil {
-			util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to get Access Control: %s", o.BlobName, err.Error()))
 			return 0, err
 		}
-		acl = aclResp.XMsACL()
+		acl = *aclResp.ACL
 	}
 
-	_, err := azblob.UploadFileToBlockBlob(
-		ctx,
-		file,
-		blobURL,
-		azblob.UploadToBlockBlobOptions{
-			BlockSize:   o.BlockSize,
-			Parallelism: o.Parallelism,
-			Metadata:    meta,
-		})
-
-	if err != nil {
-		util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to upload blob: %s", o.BlobName, err.Error()))
+	blockBlobURL := containerClient.NewBlockBlobClient(o.BlobName)
+	if err := stageAndCommitFile(ctx, blockBlobURL, o, srcFile, total, meta); err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to upload blob: %s", o.BlobName, err.Error()))
 		return 0, err
 	}
 
 	if o.HNSEnabled {
-		u, _ := url.Parse(fmt.Sprintf("https://%s.dfs.core.windows.net/%s/%s%s", o.AccountName, o.ContainerName, o.BlobName, o.ResourceSAS))
-		dfsURL := azblob.NewBlockBlobURL(*u, p)
-		/*
-			_, err = dfsURL.SetAccessControl(ctx, nil, nil, &owner, &group, &permissions, nil, nil, nil, nil, nil, nil)
-			if err != nil {
-				util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s, Failed to set owner, group and permissions: %s", o.BlobName, err.Error()))
-				return 0, err
-			}
-		*/
-		_, err = dfsURL.SetAccessControl(ctx, nil, nil, nil, nil, nil, &acl, nil, nil, nil, nil, nil)
+		_, err = fsClient.NewFileClient(o.BlobName).SetAccessControl(ctx, &file.SetAccessControlOptions{ACL: &acl})
 		if err != nil {
-			util.Log(pipeline.LogError, fmt.Sprintf("Archiving %s. Failed to set AccessControl: %s", o.BlobName, err.Error()))
+			util.Log(util.LogError, fmt.Sprintf("Archiving %s. Failed to set AccessControl: %s", o.BlobName, err.Error()))
 			//TODO: should we delete blob?
 		}
 	}
 
 	return total, err
 }
+
+func toPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,134 @@
+package lhsm_plugin_az_core
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// recordingTransport captures the last request handed to the pipeline so
+// tests can assert on the headers Archive actually sent, without needing a
+// real storage account.
+type recordingTransport struct {
+	srv        *httptest.Server
+	lastHeader http.Header
+}
+
+func (t *recordingTransport) Do(req *http.Request) (*http.Response, error) {
+	t.lastHeader = req.Header.Clone()
+	req.URL.Scheme = "http"
+	req.URL.Host = t.srv.Listener.Addr().String()
+	return http.DefaultClient.Do(req)
+}
+
+func newTestServiceClient(t *testing.T) (*azblob.Client, *recordingTransport) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"0x0"`)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("x-ms-request-server-encrypted", "true")
+		w.Header().Set("x-ms-version", "2024-08-04")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+
+	transport := &recordingTransport{srv: srv}
+	cred, err := azblob.NewSharedKeyCredential("devstoreaccount1", base64.StdEncoding.EncodeToString([]byte("fake-account-key")))
+	if err != nil {
+		t.Fatalf("building shared key credential: %s", err)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential("https://devstoreaccount1.blob.core.windows.net/", cred, &azblob.ClientOptions{
+		ClientOptions: policy.ClientOptions{Transport: transport},
+	})
+	if err != nil {
+		t.Fatalf("building service client: %s", err)
+	}
+	return client, transport
+}
+
+func TestArchiveSetsTierAndEncryptionScopeHeaders(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	if err := os.WriteFile(srcPath, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("writing source file: %s", err)
+	}
+
+	client, transport := newTestServiceClient(t)
+
+	tier := blob.AccessTierCool
+	scope := "my-scope"
+	o := ArchiveOptions{
+		ServiceClient: client,
+		ContainerName: "container",
+		MountRoot:     dir,
+		BlobName:      "src",
+		SourcePath:    srcPath,
+		Parallelism:   1,
+		BlockSize:     4 * 1024 * 1024,
+		AccessTier:    tier,
+		CPKScopeInfo:  &blob.CPKScopeInfo{EncryptionScope: &scope},
+		PlanDir:       t.TempDir(),
+	}
+
+	if _, err := Archive(o); err != nil {
+		t.Fatalf("Archive: %s", err)
+	}
+
+	h := transport.lastHeader
+	if got := h.Get("x-ms-access-tier"); got != string(tier) {
+		t.Errorf("x-ms-access-tier = %q, want %q", got, tier)
+	}
+	if got := h.Get("x-ms-encryption-scope"); got != scope {
+		t.Errorf("x-ms-encryption-scope = %q, want %q", got, scope)
+	}
+}
+
+func TestArchiveSetsCPKHeaders(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	if err := os.WriteFile(srcPath, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("writing source file: %s", err)
+	}
+
+	client, transport := newTestServiceClient(t)
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	sha := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	algo := blob.EncryptionAlgorithmTypeAES256
+
+	o := ArchiveOptions{
+		ServiceClient: client,
+		ContainerName: "container",
+		MountRoot:     dir,
+		BlobName:      "src",
+		SourcePath:    srcPath,
+		Parallelism:   1,
+		BlockSize:     4 * 1024 * 1024,
+		CPKInfo: &blob.CPKInfo{
+			EncryptionKey:       &key,
+			EncryptionKeySHA256: &sha,
+			EncryptionAlgorithm: &algo,
+		},
+		PlanDir: t.TempDir(),
+	}
+
+	if _, err := Archive(o); err != nil {
+		t.Fatalf("Archive: %s", err)
+	}
+
+	h := transport.lastHeader
+	if got := h.Get("x-ms-encryption-key"); got != key {
+		t.Errorf("x-ms-encryption-key = %q, want %q", got, key)
+	}
+	if got := h.Get("x-ms-encryption-key-sha256"); got != sha {
+		t.Errorf("x-ms-encryption-key-sha256 = %q, want %q", got, sha)
+	}
+}
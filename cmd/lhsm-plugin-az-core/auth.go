@@ -0,0 +1,156 @@
+package lhsm_plugin_az_core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	dlservice "github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/service"
+)
+
+// AuthMode selects how the plugin authenticates to the storage account. It
+// is read straight out of the plugin config (auth = "...").
+type AuthMode string
+
+const (
+	// AuthModeDefault uses azidentity.DefaultAzureCredential, which tries
+	// environment, workload identity, managed identity and azure CLI
+	// credentials in turn. Used when no auth mode is configured.
+	AuthModeDefault  AuthMode = ""
+	AuthModeMSI      AuthMode = "msi"
+	AuthModeWorkload AuthMode = "workload"
+	AuthModeSPN      AuthMode = "spn"
+	AuthModeSAS      AuthMode = "sas"
+	AuthModeKey      AuthMode = "key"
+)
+
+// AuthConfig carries whichever credential material a given AuthMode needs.
+// Only the fields relevant to the configured Mode are consulted.
+type AuthConfig struct {
+	Mode AuthMode
+
+	// AuthModeMSI: ClientID is optional and selects a user-assigned
+	// identity; when empty the system-assigned identity is used.
+	ClientID string
+
+	// AuthModeSPN: service principal via client secret or client
+	// certificate. Exactly one of ClientSecret or ClientCertPath is
+	// expected.
+	TenantID           string
+	ClientSecret       string
+	ClientCertPath     string
+	ClientCertPassword string
+
+	// AuthModeSAS: a full query-string SAS token (with or without the
+	// leading '?') appended to the account URL.
+	SAS string
+
+	// AuthModeKey: account shared key, base64 encoded as returned by the
+	// portal/CLI.
+	AccountKey string
+}
+
+// NewServiceClient builds an azblob.Client for accountName using the
+// credential described by cfg, with clientOptions applied to every request
+// the client issues (retry, pacing, logging - see util.NewClientOptions).
+func NewServiceClient(accountName string, cfg AuthConfig, clientOptions policy.ClientOptions) (*azblob.Client, error) {
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", accountName)
+
+	switch cfg.Mode {
+	case AuthModeKey:
+		cred, err := azblob.NewSharedKeyCredential(accountName, cfg.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("building shared key credential: %s", err)
+		}
+		return azblob.NewClientWithSharedKeyCredential(serviceURL, cred, &azblob.ClientOptions{ClientOptions: clientOptions})
+
+	case AuthModeSAS:
+		sas := cfg.SAS
+		if len(sas) > 0 && sas[0] != '?' {
+			sas = "?" + sas
+		}
+		return azblob.NewClientWithNoCredential(serviceURL+sas, &azblob.ClientOptions{ClientOptions: clientOptions})
+
+	default:
+		cred, err := newTokenCredential(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClient(serviceURL, cred, &azblob.ClientOptions{ClientOptions: clientOptions})
+	}
+}
+
+// NewDatalakeServiceClient builds the dfs-endpoint counterpart of
+// NewServiceClient, used for HNS account ACL preservation. It shares
+// AuthConfig with the blob client so the two stay in lockstep.
+func NewDatalakeServiceClient(accountName string, cfg AuthConfig, clientOptions policy.ClientOptions) (*dlservice.Client, error) {
+	serviceURL := fmt.Sprintf("https://%s.dfs.core.windows.net/", accountName)
+
+	switch cfg.Mode {
+	case AuthModeKey:
+		cred, err := azblob.NewSharedKeyCredential(accountName, cfg.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("building shared key credential: %s", err)
+		}
+		return dlservice.NewClientWithSharedKeyCredential(serviceURL, cred, &dlservice.ClientOptions{ClientOptions: clientOptions})
+
+	case AuthModeSAS:
+		sas := cfg.SAS
+		if len(sas) > 0 && sas[0] != '?' {
+			sas = "?" + sas
+		}
+		return dlservice.NewClientWithNoCredential(serviceURL+sas, &dlservice.ClientOptions{ClientOptions: clientOptions})
+
+	default:
+		cred, err := newTokenCredential(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return dlservice.NewClient(serviceURL, cred, &dlservice.ClientOptions{ClientOptions: clientOptions})
+	}
+}
+
+func newTokenCredential(cfg AuthConfig) (azcore.TokenCredential, error) {
+	switch cfg.Mode {
+	case AuthModeMSI:
+		opts := &azidentity.ManagedIdentityCredentialOptions{}
+		if cfg.ClientID != "" {
+			opts.ID = azidentity.ClientID(cfg.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(opts)
+	case AuthModeWorkload:
+		return azidentity.NewWorkloadIdentityCredential(nil)
+	case AuthModeSPN:
+		return newServicePrincipalCredential(cfg)
+	case AuthModeDefault:
+		return azidentity.NewDefaultAzureCredential(nil)
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Mode)
+	}
+}
+
+func newServicePrincipalCredential(cfg AuthConfig) (azcore.TokenCredential, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("spn auth requires tenant and client id")
+	}
+
+	switch {
+	case cfg.ClientSecret != "":
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+	case cfg.ClientCertPath != "":
+		certData, err := os.ReadFile(cfg.ClientCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate %s: %s", cfg.ClientCertPath, err)
+		}
+		certs, key, err := azidentity.ParseCertificates(certData, []byte(cfg.ClientCertPassword))
+		if err != nil {
+			return nil, fmt.Errorf("parsing client certificate: %s", err)
+		}
+		return azidentity.NewClientCertificateCredential(cfg.TenantID, cfg.ClientID, certs, key, nil)
+	default:
+		return nil, fmt.Errorf("spn auth requires a client secret or client certificate")
+	}
+}
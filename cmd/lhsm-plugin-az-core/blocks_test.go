@@ -0,0 +1,110 @@
+package lhsm_plugin_az_core
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wastore/lemur/cmd/util/jobs"
+)
+
+func TestStagePendingBlocksMarksBlocksStagedAndCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	if err := os.WriteFile(srcPath, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("writing source file: %s", err)
+	}
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		t.Fatalf("opening source file: %s", err)
+	}
+	defer srcFile.Close()
+
+	var stageCalls int
+	client := newTaggedServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") == "block" {
+			stageCalls++
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	blockBlobURL := client.ServiceClient().NewContainerClient("container").NewBlockBlobClient("src")
+
+	o := ArchiveOptions{BlobName: "src", Parallelism: 1}
+	planDir := t.TempDir()
+	plan := jobs.New(planDir, "job-1")
+	fp := newFilePlan(o, 11, 42)
+	plan.Files = []*jobs.FilePlan{fp}
+	if err := plan.Save(); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	if err := stagePendingBlocks(context.Background(), blockBlobURL, o, srcFile, plan, fp); err != nil {
+		t.Fatalf("stagePendingBlocks: %s", err)
+	}
+
+	if stageCalls != len(fp.BlockIDs) {
+		t.Errorf("StageBlock called %d times, want %d", stageCalls, len(fp.BlockIDs))
+	}
+	if !fp.AllStaged() {
+		t.Error("AllStaged() = false after stagePendingBlocks succeeded")
+	}
+
+	reloaded, err := jobs.Load(planDir, "job-1")
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if !reloaded.Files[0].AllStaged() {
+		t.Error("checkpointed plan on disk does not reflect the staged blocks")
+	}
+}
+
+func TestStagePendingBlocksSkipsAlreadyStagedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	if err := os.WriteFile(srcPath, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("writing source file: %s", err)
+	}
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		t.Fatalf("opening source file: %s", err)
+	}
+	defer srcFile.Close()
+
+	var stageCalls int
+	client := newTaggedServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") == "block" {
+			stageCalls++
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	blockBlobURL := client.ServiceClient().NewContainerClient("container").NewBlockBlobClient("src")
+
+	o := ArchiveOptions{BlobName: "src", Parallelism: 1, BlockSize: 4}
+	plan := jobs.New(t.TempDir(), "job-1")
+	fp := newFilePlan(o, 11, 42)
+	for i := range fp.Staged {
+		fp.Staged[i] = true
+	}
+	plan.Files = []*jobs.FilePlan{fp}
+
+	if err := stagePendingBlocks(context.Background(), blockBlobURL, o, srcFile, plan, fp); err != nil {
+		t.Fatalf("stagePendingBlocks: %s", err)
+	}
+	if stageCalls != 0 {
+		t.Errorf("StageBlock called %d times for a fully-staged plan, want 0", stageCalls)
+	}
+}
+
+func TestCommitPlanRequiresAllBlocksStaged(t *testing.T) {
+	o := ArchiveOptions{BlobName: "src"}
+	plan := jobs.New(t.TempDir(), "job-1")
+	fp := newFilePlan(o, 11, 42)
+	plan.Files = []*jobs.FilePlan{fp}
+
+	err := commitPlan(context.Background(), nil, o, nil, plan, fp)
+	if err == nil {
+		t.Fatal("commitPlan: want error when not all blocks are staged, got nil")
+	}
+}
@@ -0,0 +1,114 @@
+package lhsm_plugin_az_core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// CPKSource selects where an archive ID's customer-provided encryption key
+// comes from. The zero value means no CPK is configured for the archive ID.
+type CPKSource string
+
+const (
+	CPKSourceNone     CPKSource = ""
+	CPKSourceFile     CPKSource = "file"
+	CPKSourceEnv      CPKSource = "env"
+	CPKSourceKeyVault CPKSource = "keyvault"
+)
+
+// CPKConfig is read from the plugin config, one per archive ID.
+type CPKConfig struct {
+	Source CPKSource
+
+	// CPKSourceFile: path to a file containing the base64-encoded 256-bit
+	// key (surrounding whitespace is ignored).
+	KeyFilePath string
+	// CPKSourceEnv: name of the environment variable holding the
+	// base64-encoded key.
+	KeyEnvVar string
+	// CPKSourceKeyVault: the vault to fetch the key from, authenticated
+	// with the same credential as the storage account.
+	KeyVaultURL        string
+	KeyVaultSecretName string
+
+	// EncryptionScope, if set, is used instead of (or alongside) CPK - an
+	// encryption scope pre-created in the storage account rather than key
+	// material the plugin manages itself.
+	EncryptionScope string
+}
+
+// ResolveCPK turns cfg into the blob.CPKInfo/EncryptionScope pair that
+// Archive/Restore thread through to the blob service (RemoveOptions accepts
+// them too, but Remove does not send them). It is called
+// once at plugin startup per archive ID; a non-nil error means the archive
+// ID must not be served, since its key material couldn't be obtained.
+func ResolveCPK(ctx context.Context, cred azcore.TokenCredential, cfg CPKConfig) (*blob.CPKInfo, *blob.CPKScopeInfo, error) {
+	var scope *blob.CPKScopeInfo
+	if cfg.EncryptionScope != "" {
+		scope = &blob.CPKScopeInfo{EncryptionScope: toPtr(cfg.EncryptionScope)}
+	}
+
+	if cfg.Source == CPKSourceNone {
+		return nil, scope, nil
+	}
+
+	key, err := resolveCPKKey(ctx, cred, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving CPK for source %q: %s", cfg.Source, err)
+	}
+
+	sum := sha256.Sum256(key)
+	info := &blob.CPKInfo{
+		EncryptionKey:       toPtr(base64.StdEncoding.EncodeToString(key)),
+		EncryptionKeySHA256: toPtr(base64.StdEncoding.EncodeToString(sum[:])),
+		EncryptionAlgorithm: toEncryptionAlgorithmPtr(blob.EncryptionAlgorithmTypeAES256),
+	}
+	return info, scope, nil
+}
+
+func resolveCPKKey(ctx context.Context, cred azcore.TokenCredential, cfg CPKConfig) ([]byte, error) {
+	switch cfg.Source {
+	case CPKSourceFile:
+		raw, err := os.ReadFile(cfg.KeyFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file %s: %s", cfg.KeyFilePath, err)
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+
+	case CPKSourceEnv:
+		raw, ok := os.LookupEnv(cfg.KeyEnvVar)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", cfg.KeyEnvVar)
+		}
+		return base64.StdEncoding.DecodeString(raw)
+
+	case CPKSourceKeyVault:
+		client, err := azsecrets.NewClient(cfg.KeyVaultURL, cred, nil)
+		if err != nil {
+			return nil, fmt.Errorf("building key vault client for %s: %s", cfg.KeyVaultURL, err)
+		}
+		resp, err := client.GetSecret(ctx, cfg.KeyVaultSecretName, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("fetching secret %s from %s: %s", cfg.KeyVaultSecretName, cfg.KeyVaultURL, err)
+		}
+		if resp.Value == nil {
+			return nil, fmt.Errorf("secret %s in %s has no value", cfg.KeyVaultSecretName, cfg.KeyVaultURL)
+		}
+		return base64.StdEncoding.DecodeString(*resp.Value)
+
+	default:
+		return nil, fmt.Errorf("unknown CPK source %q", cfg.Source)
+	}
+}
+
+func toEncryptionAlgorithmPtr(a blob.EncryptionAlgorithmType) *blob.EncryptionAlgorithmType {
+	return &a
+}
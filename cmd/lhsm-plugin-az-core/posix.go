@@ -0,0 +1,213 @@
+package lhsm_plugin_az_core
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrNameEncoding turns an xattr name like "user.comment" into a blob
+// metadata key-safe token (letters and digits only - no '.', no padding)
+// and back, so the original namespaced name can be restored exactly.
+var xattrNameEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// posixMetaKey is the blob metadata key the whole statx(2)-derived schema is
+// packed into. xattrs are kept out of it, one key per attribute, so a
+// listing tool can spot them without deserializing the JSON blob.
+const posixMetaKey = "posix"
+
+// xattrMetaPrefix prefixes per-xattr metadata keys, e.g.
+// "xattr-" + base32("user.checksum"). Values are base64 encoded.
+const xattrMetaPrefix = "xattr-"
+
+// posixSchemaVersion is bumped whenever the PosixMetadata JSON shape
+// changes incompatibly. Restore tolerates any version <= the one it knows
+// about, and any version missing fields it expects (for forward migration
+// from the current plain-metadata scheme, which has no schema version at
+// all).
+const posixSchemaVersion = 1
+
+// PosixMetadata is the statx(2)-inspired snapshot of a file or directory's
+// POSIX metadata, preserved across Archive/Restore as a single JSON blob
+// metadata value (see posixMetaKey).
+type PosixMetadata struct {
+	SchemaVersion int `json:"schema_version"`
+
+	Mode uint32 `json:"mode"`
+	UID  uint32 `json:"uid"`
+	GID  uint32 `json:"gid"`
+
+	MtimeNsec int64 `json:"mtime_nsec"`
+	AtimeNsec int64 `json:"atime_nsec"`
+	// BtimeNsec is omitted when the underlying filesystem doesn't report a
+	// creation time.
+	BtimeNsec *int64 `json:"btime_nsec,omitempty"`
+
+	Dev   uint64 `json:"dev"`
+	Ino   uint64 `json:"ino"`
+	Nlink uint64 `json:"nlink"`
+}
+
+// capturePosixMetadata reads the POSIX metadata for path from info.Sys()
+// (a syscall.Stat_t) and adds btime from statx(2) when the underlying
+// filesystem reports it.
+func capturePosixMetadata(filePath string, info os.FileInfo) (*PosixMetadata, error) {
+	st := info.Sys().(*syscall.Stat_t)
+
+	m := &PosixMetadata{
+		SchemaVersion: posixSchemaVersion,
+		Mode:          uint32(st.Mode),
+		UID:           st.Uid,
+		GID:           st.Gid,
+		MtimeNsec:     st.Mtim.Nano(),
+		AtimeNsec:     st.Atim.Nano(),
+		Dev:           uint64(st.Dev),
+		Ino:           st.Ino,
+		Nlink:         uint64(st.Nlink),
+	}
+
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, filePath, 0, unix.STATX_BTIME, &stx); err == nil && stx.Mask&unix.STATX_BTIME != 0 {
+		btime := stx.Btime.Sec*int64(time.Second) + int64(stx.Btime.Nsec)
+		m.BtimeNsec = &btime
+	}
+
+	return m, nil
+}
+
+// listXattrs returns the extended attributes set on path, keyed by xattr
+// name. A filesystem that doesn't support xattrs (ENOTSUP) is treated as
+// "no xattrs" rather than an error.
+func listXattrs(filePath string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(filePath, nil)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing xattrs on %s: %s", filePath, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	n, err := unix.Llistxattr(filePath, buf)
+	if err != nil {
+		return nil, fmt.Errorf("listing xattrs on %s: %s", filePath, err)
+	}
+
+	xattrs := map[string][]byte{}
+	for _, name := range strings.Split(strings.Trim(string(buf[:n]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(filePath, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("reading xattr %s on %s: %s", name, filePath, err)
+		}
+		val := make([]byte, vsize)
+		if vsize > 0 {
+			if _, err := unix.Lgetxattr(filePath, name, val); err != nil {
+				return nil, fmt.Errorf("reading xattr %s on %s: %s", name, filePath, err)
+			}
+		}
+		xattrs[name] = val
+	}
+	return xattrs, nil
+}
+
+// posixBlobMetadata packs m and xattrs into the blob metadata keys Archive
+// sends up. All xattr values are base64 encoded, since blob metadata
+// values must be valid HTTP header strings.
+func posixBlobMetadata(m *PosixMetadata, xattrs map[string][]byte) (map[string]*string, error) {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling posix metadata: %s", err)
+	}
+
+	meta := map[string]*string{
+		posixMetaKey: toPtr(string(buf)),
+	}
+	for name, val := range xattrs {
+		meta[xattrMetaPrefix+xattrNameEncoding.EncodeToString([]byte(name))] = toPtr(base64.StdEncoding.EncodeToString(val))
+	}
+	return meta, nil
+}
+
+// parsePosixBlobMetadata is the Restore-side inverse of posixBlobMetadata.
+// It tolerates a missing posixMetaKey (today's plain-metadata blobs) by
+// returning a nil *PosixMetadata and no error.
+func parsePosixBlobMetadata(meta map[string]*string) (*PosixMetadata, map[string][]byte, error) {
+	raw, ok := meta[posixMetaKey]
+	if !ok || raw == nil {
+		return nil, nil, nil
+	}
+
+	var m PosixMetadata
+	if err := json.Unmarshal([]byte(*raw), &m); err != nil {
+		return nil, nil, fmt.Errorf("unmarshaling posix metadata: %s", err)
+	}
+
+	xattrs := map[string][]byte{}
+	for key, val := range meta {
+		encodedName, ok := strings.CutPrefix(key, xattrMetaPrefix)
+		if !ok || val == nil {
+			continue
+		}
+		nameBytes, err := xattrNameEncoding.DecodeString(strings.ToUpper(encodedName))
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding xattr name %s: %s", key, err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(*val)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding xattr metadata %s: %s", key, err)
+		}
+		xattrs[string(nameBytes)] = decoded
+	}
+
+	return &m, xattrs, nil
+}
+
+// applyPosixMetadata restores mode/owner/times/xattrs onto filePath. Fields
+// that are absent (older schema versions, or fields a given filesystem
+// never reported) are left at whatever the fresh file/directory naturally
+// got, rather than erroring.
+func applyPosixMetadata(filePath string, m *PosixMetadata, xattrs map[string][]byte) error {
+	if m == nil {
+		return nil
+	}
+
+	if m.Mode != 0 {
+		if err := os.Chmod(filePath, os.FileMode(m.Mode&07777)); err != nil {
+			return fmt.Errorf("restoring mode on %s: %s", filePath, err)
+		}
+	}
+
+	if err := os.Lchown(filePath, int(m.UID), int(m.GID)); err != nil {
+		return fmt.Errorf("restoring owner on %s: %s", filePath, err)
+	}
+
+	if m.MtimeNsec != 0 || m.AtimeNsec != 0 {
+		atime := time.Unix(0, m.AtimeNsec)
+		mtime := time.Unix(0, m.MtimeNsec)
+		if err := os.Chtimes(filePath, atime, mtime); err != nil {
+			return fmt.Errorf("restoring times on %s: %s", filePath, err)
+		}
+	}
+
+	for name, val := range xattrs {
+		if err := unix.Lsetxattr(filePath, name, val, 0); err != nil {
+			return fmt.Errorf("restoring xattr %s on %s: %s", name, filePath, err)
+		}
+	}
+
+	return nil
+}
+
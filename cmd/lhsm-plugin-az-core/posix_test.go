@@ -0,0 +1,39 @@
+package lhsm_plugin_az_core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPosixBlobMetadataXattrNameRoundTrip(t *testing.T) {
+	m := &PosixMetadata{SchemaVersion: posixSchemaVersion, Mode: 0o644}
+	xattrs := map[string][]byte{
+		"user.comment":           []byte("hello"),
+		"trusted.overlay.origin": {0xde, 0xad, 0xbe, 0xef},
+		"security.selinux":       []byte("unconfined_u:object_r:default_t:s0"),
+	}
+
+	meta, err := posixBlobMetadata(m, xattrs)
+	if err != nil {
+		t.Fatalf("posixBlobMetadata: %s", err)
+	}
+
+	_, xattrsOut, err := parsePosixBlobMetadata(meta)
+	if err != nil {
+		t.Fatalf("parsePosixBlobMetadata: %s", err)
+	}
+
+	if len(xattrsOut) != len(xattrs) {
+		t.Fatalf("got %d xattrs back, want %d", len(xattrsOut), len(xattrs))
+	}
+	for name, want := range xattrs {
+		got, ok := xattrsOut[name]
+		if !ok {
+			t.Errorf("xattr %q missing after round trip (name was not preserved)", name)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("xattr %q = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -3,33 +3,60 @@ package lhsm_plugin_az_core
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"path"
 
-	"github.com/Azure/azure-pipeline-go/pipeline"
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/wastore/lemur/cmd/util"
 )
 
 type RemoveOptions struct {
-	AccountName   string
+	// ServiceClient is built once by the caller (via NewServiceClient) and
+	// reused across remove requests for the same account.
+	ServiceClient *azblob.Client
 	ContainerName string
-	ResourceSAS   string
 	BlobName      string
 	ExportPrefix  string
-	Credential    azblob.Credential
+	// CPKInfo and CPKScopeInfo are accepted for symmetry with
+	// ArchiveOptions/RestoreOptions. Deleting a blob doesn't require
+	// decrypting its content, so they're currently unused by Delete itself.
+	CPKInfo      *blob.CPKInfo
+	CPKScopeInfo *blob.CPKScopeInfo
+
+	// IncludeTagFilter, if set, must match the blob's tags (a query like
+	// `retentionClass = 'legal-hold' AND fsname = 'lustre01'`) or Delete is
+	// skipped. ExcludeTagFilter is the inverse: Delete is skipped if the
+	// blob's tags match it. Either or both may be set; both are evaluated
+	// against a single GetTags call before Delete runs.
+	IncludeTagFilter string
+	ExcludeTagFilter string
 }
 
 func Remove(o RemoveOptions) error {
 	ctx := context.TODO()
-	p := azblob.NewPipeline(o.Credential, azblob.PipelineOptions{})
-	blobPath := path.Join(o.ContainerName, o.ExportPrefix, o.BlobName)
-	u, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s%s", o.AccountName, blobPath, o.ResourceSAS))
+	blobPath := path.Join(o.ExportPrefix, o.BlobName)
+	containerClient := o.ServiceClient.ServiceClient().NewContainerClient(o.ContainerName)
+	blobClient := containerClient.NewBlobClient(blobPath)
+
+	util.Log(util.LogInfo, fmt.Sprintf("Removing %s/%s.", o.ContainerName, blobPath))
 
-	util.Log(pipeline.LogInfo, fmt.Sprintf("Removing %s.", u.String()))
+	skip, reason, err := shouldSkipForTagFilter(ctx, blobClient, o.IncludeTagFilter, o.ExcludeTagFilter)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Removing %s/%s. Failed to evaluate tag filter: %s", o.ContainerName, blobPath, err.Error()))
+		return err
+	}
+	if skip {
+		util.Log(util.LogInfo, fmt.Sprintf("Removing %s/%s. Skipped: %s", o.ContainerName, blobPath, reason))
+		return nil
+	}
 
-	// fetch the properties first so that we know how big the source blob is
-	blobURL := azblob.NewBlobURL(*u, p)
-	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	_, err = blobClient.Delete(ctx, &azblob.DeleteBlobOptions{
+		DeleteSnapshots: deleteSnapshotsIncludePtr(),
+	})
 	return err
 }
+
+func deleteSnapshotsIncludePtr() *azblob.DeleteSnapshotsOptionType {
+	v := azblob.DeleteSnapshotsOptionTypeInclude
+	return &v
+}
@@ -0,0 +1,204 @@
+package lhsm_plugin_az_core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/wastore/lemur/cmd/util"
+)
+
+// ErrRehydratePending is returned by Restore when the blob lives in the
+// Archive tier. A rehydration to RestoreOptions.AccessTier has either just
+// been kicked off, or was already in flight. Callers should surface HSM
+// status "pending" to the coordinator and retry Restore later rather than
+// treating this as a terminal error.
+var ErrRehydratePending = errors.New("lhsm_plugin_az_core: blob is rehydrating from archive tier")
+
+// ErrSkippedByTagFilter is returned by Restore when RestoreOptions.IncludeTagFilter
+// or ExcludeTagFilter caused the blob to be skipped. Callers should treat this
+// like a deliberate no-op rather than a failed restore.
+var ErrSkippedByTagFilter = errors.New("lhsm_plugin_az_core: blob skipped by tag filter")
+
+type RestoreOptions struct {
+	// ServiceClient is built once by the caller (via NewServiceClient) and
+	// reused across restore requests for the same account.
+	ServiceClient *azblob.Client
+	ContainerName string
+	BlobName      string
+	DestPath      string
+	Parallelism   uint16
+	BlockSize     int64
+	Pacer         util.Pacer
+
+	// AccessTier is the tier an archive-tier blob is rehydrated into
+	// before it can be read. Defaults to blob.AccessTierHot.
+	AccessTier blob.AccessTier
+	// RehydratePriority controls how quickly the service services the
+	// rehydration. Defaults to blob.RehydratePriorityStandard.
+	RehydratePriority blob.RehydratePriority
+	// RehydrateStartedAt is when this blob's rehydration was first
+	// requested, so repeated Restore calls (a polling loop) can bound how
+	// long they're willing to wait. Left zero on the first call.
+	RehydrateStartedAt time.Time
+	// MaxRehydrateWait errors Restore out once RehydrateStartedAt is this
+	// old. Zero means wait indefinitely.
+	MaxRehydrateWait time.Duration
+
+	// CPKInfo and CPKScopeInfo decrypt content uploaded with a
+	// customer-provided key or encryption scope, respectively. Must match
+	// whatever Archive used for this blob; see ResolveCPK.
+	CPKInfo      *blob.CPKInfo
+	CPKScopeInfo *blob.CPKScopeInfo
+
+	// IncludeTagFilter and ExcludeTagFilter guard Restore the same way they
+	// guard Remove: see RemoveOptions.
+	IncludeTagFilter string
+	ExcludeTagFilter string
+}
+
+// Restore downloads a blob back onto the local filesystem at o.DestPath and
+// applies whatever POSIX metadata was preserved at Archive time. If the
+// blob is sitting in the Archive tier, Restore instead kicks off (or checks
+// on) rehydration and returns ErrRehydratePending - the caller is expected
+// to report HSM status "pending" and call Restore again later.
+func Restore(o RestoreOptions) (int64, error) {
+	restoreCtx := context.Background()
+	ctx, cancel := context.WithCancel(restoreCtx)
+	defer cancel()
+
+	util.Log(util.LogInfo, fmt.Sprintf("Restoring %s", o.BlobName))
+
+	containerClient := o.ServiceClient.ServiceClient().NewContainerClient(o.ContainerName)
+	blobClient := containerClient.NewBlockBlobClient(o.BlobName)
+
+	skip, reason, err := shouldSkipForTagFilter(ctx, blobClient, o.IncludeTagFilter, o.ExcludeTagFilter)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Restoring %s. Failed to evaluate tag filter: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+	if skip {
+		util.Log(util.LogInfo, fmt.Sprintf("Restoring %s. Skipped: %s", o.BlobName, reason))
+		return 0, ErrSkippedByTagFilter
+	}
+
+	props, err := blobClient.GetProperties(ctx, &blob.GetPropertiesOptions{CPKInfo: o.CPKInfo})
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Restoring %s. Failed to get properties: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+
+	if props.AccessTier != nil && *props.AccessTier == string(blob.AccessTierArchive) {
+		return 0, handleArchiveTier(ctx, blobClient, o, props.ArchiveStatus)
+	}
+
+	destFile, err := os.Create(o.DestPath)
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Restoring %s. Failed to create destination file: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+	defer destFile.Close()
+
+	_, err = o.ServiceClient.DownloadFile(ctx, o.ContainerName, o.BlobName, destFile, &azblob.DownloadFileOptions{
+		BlockSize:    o.BlockSize,
+		Concurrency:  uint16(o.Parallelism),
+		CPKInfo:      o.CPKInfo,
+		CPKScopeInfo: o.CPKScopeInfo,
+	})
+	if err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Restoring %s. Failed to download blob: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+
+	if err := restoreMetadata(o.DestPath, props.Metadata); err != nil {
+		util.Log(util.LogError, fmt.Sprintf("Restoring %s. Failed to restore metadata: %s", o.BlobName, err.Error()))
+		return 0, err
+	}
+
+	var total int64
+	if props.ContentLength != nil {
+		total = *props.ContentLength
+	}
+	return total, nil
+}
+
+// handleArchiveTier kicks off rehydration of an archive-tier blob the first
+// time it's seen, and on subsequent calls reports whether it's still in
+// progress, bounded by o.MaxRehydrateWait.
+func handleArchiveTier(ctx context.Context, blobClient *blockblob.Client, o RestoreOptions, archiveStatus *string) error {
+	if archiveStatus == nil {
+		tier := o.AccessTier
+		if tier == "" {
+			tier = blob.AccessTierHot
+		}
+		priority := o.RehydratePriority
+		if priority == "" {
+			priority = blob.RehydratePriorityStandard
+		}
+
+		_, err := blobClient.SetTier(ctx, tier, &blob.SetTierOptions{RehydratePriority: &priority})
+		if err != nil {
+			util.Log(util.LogError, fmt.Sprintf("Restoring %s. Failed to start rehydration: %s", o.BlobName, err.Error()))
+			return err
+		}
+		util.Log(util.LogInfo, fmt.Sprintf("Restoring %s. Rehydration to %s started.", o.BlobName, tier))
+		return ErrRehydratePending
+	}
+
+	if o.MaxRehydrateWait > 0 && !o.RehydrateStartedAt.IsZero() && time.Since(o.RehydrateStartedAt) > o.MaxRehydrateWait {
+		return fmt.Errorf("rehydrating %s exceeded max wait of %s (status: %s)", o.BlobName, o.MaxRehydrateWait, *archiveStatus)
+	}
+
+	util.Log(util.LogInfo, fmt.Sprintf("Restoring %s. Rehydration in progress: %s", o.BlobName, *archiveStatus))
+	return ErrRehydratePending
+}
+
+// restoreMetadata applies the posix schema when present, falling back to
+// the plain Permissions and Owner/Group metadata of blobs archived before
+// the posix schema existed. The legacy ModTime value is not applied.
+func restoreMetadata(destPath string, meta map[string]*string) error {
+	posixMeta, xattrs, err := parsePosixBlobMetadata(meta)
+	if err != nil {
+		return err
+	}
+	if posixMeta != nil {
+		return applyPosixMetadata(destPath, posixMeta, xattrs)
+	}
+	return restoreLegacyMetadata(destPath, meta)
+}
+
+func restoreLegacyMetadata(destPath string, meta map[string]*string) error {
+	if perm := meta["Permissions"]; perm != nil {
+		mode, err := strconv.ParseUint(*perm, 8, 32)
+		if err != nil {
+			return fmt.Errorf("parsing legacy Permissions metadata: %s", err)
+		}
+		if err := os.Chmod(destPath, os.FileMode(mode&07777)); err != nil {
+			return fmt.Errorf("restoring legacy permissions: %s", err)
+		}
+	}
+
+	owner := meta["Owner"]
+	group := meta["Group"]
+	if owner != nil && group != nil {
+		uid, err := strconv.ParseUint(*owner, 10, 32)
+		if err != nil {
+			return fmt.Errorf("parsing legacy Owner metadata: %s", err)
+		}
+		gid, err := strconv.ParseUint(*group, 10, 32)
+		if err != nil {
+			return fmt.Errorf("parsing legacy Group metadata: %s", err)
+		}
+		if err := os.Lchown(destPath, int(uid), int(gid)); err != nil {
+			return fmt.Errorf("restoring legacy owner: %s", err)
+		}
+	}
+
+	return nil
+}
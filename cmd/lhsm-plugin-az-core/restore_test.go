@@ -0,0 +1,76 @@
+package lhsm_plugin_az_core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+)
+
+func newTestBlockBlobClient(t *testing.T, setTierCalled *bool) *blockblob.Client {
+	t.Helper()
+
+	client := newTaggedServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") == "tier" {
+			if setTierCalled != nil {
+				*setTierCalled = true
+			}
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	containerClient := client.ServiceClient().NewContainerClient("container")
+	return containerClient.NewBlockBlobClient("src")
+}
+
+func TestHandleArchiveTierStartsRehydrationOnFirstCall(t *testing.T) {
+	var setTierCalled bool
+	blobClient := newTestBlockBlobClient(t, &setTierCalled)
+
+	err := handleArchiveTier(context.Background(), blobClient, RestoreOptions{BlobName: "src"}, nil)
+	if !errors.Is(err, ErrRehydratePending) {
+		t.Fatalf("handleArchiveTier error = %v, want ErrRehydratePending", err)
+	}
+	if !setTierCalled {
+		t.Error("SetTier was not called on first sighting of an archive-tier blob")
+	}
+}
+
+func TestHandleArchiveTierReportsPendingWithoutRestartingRehydration(t *testing.T) {
+	var setTierCalled bool
+	blobClient := newTestBlockBlobClient(t, &setTierCalled)
+
+	status := "rehydrate-pending-to-hot"
+	o := RestoreOptions{
+		BlobName:           "src",
+		RehydrateStartedAt: time.Now(),
+		MaxRehydrateWait:   time.Hour,
+	}
+	err := handleArchiveTier(context.Background(), blobClient, o, &status)
+	if !errors.Is(err, ErrRehydratePending) {
+		t.Fatalf("handleArchiveTier error = %v, want ErrRehydratePending", err)
+	}
+	if setTierCalled {
+		t.Error("SetTier was called again for a rehydration already in progress")
+	}
+}
+
+func TestHandleArchiveTierErrorsOutPastMaxRehydrateWait(t *testing.T) {
+	blobClient := newTestBlockBlobClient(t, nil)
+
+	status := "rehydrate-pending-to-hot"
+	o := RestoreOptions{
+		BlobName:           "src",
+		RehydrateStartedAt: time.Now().Add(-2 * time.Hour),
+		MaxRehydrateWait:   time.Hour,
+	}
+	err := handleArchiveTier(context.Background(), blobClient, o, &status)
+	if err == nil || errors.Is(err, ErrRehydratePending) {
+		t.Fatalf("handleArchiveTier error = %v, want a terminal max-wait error", err)
+	}
+}
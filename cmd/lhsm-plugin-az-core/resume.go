@@ -0,0 +1,116 @@
+package lhsm_plugin_az_core
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/wastore/lemur/cmd/util/jobs"
+)
+
+// Resume picks a previously started archive job back up: it re-reads the
+// plan for jobID, reconciles its bitmap against the blocks the service
+// already has staged (in case the plugin crashed between StageBlock and the
+// local checkpoint), re-stages whatever is still missing, and commits.
+func Resume(jobID string, o ArchiveOptions) (int64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	planDir := o.PlanDir
+	if planDir == "" {
+		planDir = jobs.DefaultPlanDir
+	}
+
+	plan, err := jobs.Load(planDir, jobID)
+	if err != nil {
+		return 0, fmt.Errorf("resuming %s: %s", jobID, err)
+	}
+	if len(plan.Files) == 0 {
+		return 0, fmt.Errorf("resuming %s: plan has no files", jobID)
+	}
+	fp := plan.Files[0]
+
+	srcFile, err := os.Open(fp.SourcePath)
+	if err != nil {
+		return 0, fmt.Errorf("resuming %s: %s", jobID, err)
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("resuming %s: %s", jobID, err)
+	}
+
+	// The plan's block IDs are positional, not content-derived (see
+	// stageAndCommitFile): if the source changed since the plan was
+	// written, staging the remaining blocks from the new content and
+	// committing alongside already-staged blocks from the old content
+	// would silently corrupt the blob. Refuse instead of guessing; the
+	// caller should submit a fresh Archive request for the new content.
+	if !fp.MatchesSource(info.Size(), info.ModTime().UnixNano()) {
+		return 0, fmt.Errorf("resuming %s: source file %s changed since the plan was written; submit a new archive request instead of resuming", jobID, fp.SourcePath)
+	}
+
+	containerClient := o.ServiceClient.ServiceClient().NewContainerClient(o.ContainerName)
+	blockBlobURL := containerClient.NewBlockBlobClient(fp.TargetBlob)
+
+	if err := reconcileStagedBlocks(ctx, blockBlobURL, fp); err != nil {
+		return 0, err
+	}
+	if err := plan.Save(); err != nil {
+		return 0, err
+	}
+
+	o.BlobName = fp.TargetBlob
+	o.SourcePath = fp.SourcePath
+	o.BlockSize = fp.BlockSize
+	o.AccessTier = blob.AccessTier(fp.Tier)
+
+	if err := stagePendingBlocks(ctx, blockBlobURL, o, srcFile, plan, fp); err != nil {
+		return 0, err
+	}
+
+	posixMeta, err := capturePosixMetadata(fp.SourcePath, info)
+	if err != nil {
+		return 0, fmt.Errorf("resuming %s: %s", jobID, err)
+	}
+	xattrs, err := listXattrs(fp.SourcePath)
+	if err != nil {
+		return 0, fmt.Errorf("resuming %s: %s", jobID, err)
+	}
+	meta, err := posixBlobMetadata(posixMeta, xattrs)
+	if err != nil {
+		return 0, fmt.Errorf("resuming %s: %s", jobID, err)
+	}
+
+	if err := commitPlan(ctx, blockBlobURL, o, meta, plan, fp); err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// reconcileStagedBlocks asks the service which blocks it already has
+// staged for this blob and marks fp's bitmap accordingly, so Resume doesn't
+// re-upload blocks that made it last time but weren't checkpointed locally.
+func reconcileStagedBlocks(ctx context.Context, blockBlobURL *blockblob.Client, fp *jobs.FilePlan) error {
+	resp, err := blockBlobURL.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	if err != nil {
+		return fmt.Errorf("listing uncommitted blocks for %s: %s", fp.TargetBlob, err)
+	}
+
+	staged := make(map[string]bool, len(resp.UncommittedBlocks))
+	for _, b := range resp.UncommittedBlocks {
+		if b.Name != nil {
+			staged[*b.Name] = true
+		}
+	}
+
+	for i, id := range fp.BlockIDs {
+		if staged[id] {
+			fp.Staged[i] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package lhsm_plugin_az_core
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// tagGetter is satisfied by both *blob.Client and *blockblob.Client (which
+// embeds one), so shouldSkipForTagFilter works against either the Remove or
+// the Restore/Archive blob client without a conversion.
+type tagGetter interface {
+	GetTags(ctx context.Context, options *blob.GetTagsOptions) (blob.GetTagsResponse, error)
+}
+
+// fetchBlobTags reads the index tags currently set on a blob.
+func fetchBlobTags(ctx context.Context, client tagGetter) (map[string]string, error) {
+	resp, err := client.GetTags(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting tags: %s", err)
+	}
+
+	tags := make(map[string]string, len(resp.BlobTagSet))
+	for _, t := range resp.BlobTagSet {
+		if t.Key != nil && t.Value != nil {
+			tags[*t.Key] = *t.Value
+		}
+	}
+	return tags, nil
+}
+
+// shouldSkipForTagFilter fetches client's tags and decides whether the
+// caller's operation (Remove, Restore) should be skipped: includeFilter
+// must match (skip if it doesn't), and excludeFilter must not match (skip
+// if it does). Either filter may be empty. The returned reason is suitable
+// for logging and is empty when the operation should proceed.
+func shouldSkipForTagFilter(ctx context.Context, client tagGetter, includeFilter, excludeFilter string) (bool, string, error) {
+	if includeFilter == "" && excludeFilter == "" {
+		return false, "", nil
+	}
+
+	tags, err := fetchBlobTags(ctx, client)
+	if err != nil {
+		return false, "", err
+	}
+
+	if includeFilter != "" {
+		matched, err := evaluateTagFilter(tags, includeFilter)
+		if err != nil {
+			return false, "", fmt.Errorf("evaluating include tag filter: %s", err)
+		}
+		if !matched {
+			return true, fmt.Sprintf("tags do not match include filter %q", includeFilter), nil
+		}
+	}
+
+	if excludeFilter != "" {
+		matched, err := evaluateTagFilter(tags, excludeFilter)
+		if err != nil {
+			return false, "", fmt.Errorf("evaluating exclude tag filter: %s", err)
+		}
+		if matched {
+			return true, fmt.Sprintf("tags match exclude filter %q", excludeFilter), nil
+		}
+	}
+
+	return false, "", nil
+}
+
+var (
+	tagFilterAndSplit  = regexp.MustCompile(`(?i)\s+AND\s+`)
+	tagFilterClauseExp = regexp.MustCompile(`^"?([^"=\s]+)"?\s*=\s*'([^']*)'$`)
+)
+
+// evaluateTagFilter applies a small subset of the tag query syntax used by
+// the service's FindBlobsByTags filter (`"key" = 'value' AND ...`) against
+// an already-fetched tag set, so Remove/Restore can reuse the same
+// expressions operators write for FindBlobsByTags without a round trip per
+// candidate blob.
+func evaluateTagFilter(tags map[string]string, filter string) (bool, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return true, nil
+	}
+
+	for _, clause := range tagFilterAndSplit.Split(filter, -1) {
+		m := tagFilterClauseExp.FindStringSubmatch(strings.TrimSpace(clause))
+		if m == nil {
+			return false, fmt.Errorf("invalid tag filter clause %q", clause)
+		}
+		key, value := m[1], m[2]
+		if tags[key] != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
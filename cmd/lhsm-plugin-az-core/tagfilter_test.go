@@ -0,0 +1,162 @@
+package lhsm_plugin_az_core
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+func TestEvaluateTagFilter(t *testing.T) {
+	tags := map[string]string{"retentionClass": "legal-hold", "fsname": "lustre01"}
+
+	cases := []struct {
+		name    string
+		filter  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "empty filter always matches", filter: "", want: true},
+		{name: "single clause matches", filter: "retentionClass = 'legal-hold'", want: true},
+		{name: "single clause mismatches", filter: "retentionClass = 'none'", want: false},
+		{name: "AND chain all match", filter: "retentionClass = 'legal-hold' AND fsname = 'lustre01'", want: true},
+		{name: "AND chain one mismatches", filter: "retentionClass = 'legal-hold' AND fsname = 'lustre02'", want: false},
+		{name: "AND is case-insensitive", filter: "retentionClass = 'legal-hold' and fsname = 'lustre01'", want: true},
+		{name: "missing tag mismatches", filter: "uid = '0'", want: false},
+		{name: "malformed clause errors", filter: "retentionClass legal-hold", wantErr: true},
+		{name: "unterminated quote errors", filter: "retentionClass = 'legal-hold", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := evaluateTagFilter(tags, c.filter)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("evaluateTagFilter(%q): want error, got nil", c.filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evaluateTagFilter(%q): unexpected error: %s", c.filter, err)
+			}
+			if got != c.want {
+				t.Errorf("evaluateTagFilter(%q) = %v, want %v", c.filter, got, c.want)
+			}
+		})
+	}
+}
+
+// handlerTransport routes every request to srv, unlike recordingTransport it
+// doesn't assume a single canned response - the caller's handler decides how
+// to answer based on the request (e.g. comp=tags vs. a plain DELETE).
+type handlerTransport struct {
+	srv *httptest.Server
+}
+
+func (t *handlerTransport) Do(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = "http"
+	req.URL.Host = t.srv.Listener.Addr().String()
+	return http.DefaultClient.Do(req)
+}
+
+// newTaggedServiceClient builds an azblob.Client backed by handler, for
+// tests that need more than one kind of response (e.g. GetTags then
+// Delete) from the same fake server.
+func newTaggedServiceClient(t *testing.T, handler http.HandlerFunc) *azblob.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	cred, err := azblob.NewSharedKeyCredential("devstoreaccount1", base64.StdEncoding.EncodeToString([]byte("fake-account-key")))
+	if err != nil {
+		t.Fatalf("building shared key credential: %s", err)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential("https://devstoreaccount1.blob.core.windows.net/", cred, &azblob.ClientOptions{
+		ClientOptions: policy.ClientOptions{Transport: &handlerTransport{srv: srv}},
+	})
+	if err != nil {
+		t.Fatalf("building service client: %s", err)
+	}
+	return client
+}
+
+func tagsHandler(tags map[string]string, deleteCalled *bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("comp") == "tags":
+			var body string
+			for k, v := range tags {
+				body += fmt.Sprintf("<Tag><Key>%s</Key><Value>%s</Value></Tag>", k, v)
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Tags><TagSet>%s</TagSet></Tags>`, body)
+		case r.Method == http.MethodDelete:
+			if deleteCalled != nil {
+				*deleteCalled = true
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func TestRemoveSkipsWhenTagsDoNotMatchIncludeFilter(t *testing.T) {
+	var deleteCalled bool
+	client := newTaggedServiceClient(t, tagsHandler(map[string]string{"fsname": "lustre01"}, &deleteCalled))
+
+	o := RemoveOptions{
+		ServiceClient:    client,
+		ContainerName:    "container",
+		BlobName:         "src",
+		IncludeTagFilter: "retentionClass = 'legal-hold'",
+	}
+	if err := Remove(o); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if deleteCalled {
+		t.Error("Delete ran despite the blob's tags not matching IncludeTagFilter")
+	}
+}
+
+func TestRemoveDeletesWhenTagsMatchIncludeFilter(t *testing.T) {
+	var deleteCalled bool
+	client := newTaggedServiceClient(t, tagsHandler(map[string]string{"retentionClass": "legal-hold"}, &deleteCalled))
+
+	o := RemoveOptions{
+		ServiceClient:    client,
+		ContainerName:    "container",
+		BlobName:         "src",
+		IncludeTagFilter: "retentionClass = 'legal-hold'",
+	}
+	if err := Remove(o); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if !deleteCalled {
+		t.Error("Delete did not run even though the blob's tags matched IncludeTagFilter")
+	}
+}
+
+func TestRestoreSkipsWhenTagsMatchExcludeFilter(t *testing.T) {
+	client := newTaggedServiceClient(t, tagsHandler(map[string]string{"retentionClass": "legal-hold"}, nil))
+
+	o := RestoreOptions{
+		ServiceClient:    client,
+		ContainerName:    "container",
+		BlobName:         "src",
+		DestPath:         filepath.Join(t.TempDir(), "out"),
+		ExcludeTagFilter: "retentionClass = 'legal-hold'",
+	}
+	_, err := Restore(o)
+	if !errors.Is(err, ErrSkippedByTagFilter) {
+		t.Fatalf("Restore error = %v, want ErrSkippedByTagFilter", err)
+	}
+}
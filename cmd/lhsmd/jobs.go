@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+
+	"github.intel.com/hpdd/logging/applog"
+
+	"github.com/wastore/lemur/cmd/util/jobs"
+)
+
+func init() {
+	jobsCommand := cli.Command{
+		Name:  "jobs",
+		Usage: "Inspect and manage on-disk HSM archive/restore job plans",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the job IDs with a plan file on disk",
+				Flags:  planDirFlags(),
+				Action: jobsListAction,
+			},
+			{
+				Name:      "show",
+				Usage:     "Show the per-file staging status of a job plan",
+				ArgsUsage: "<job-id>",
+				Flags:     planDirFlags(),
+				Action:    jobsShowAction,
+			},
+			{
+				Name:   "clean",
+				Usage:  "Remove plan files for jobs that have fully committed",
+				Flags:  planDirFlags(),
+				Action: jobsCleanAction,
+			},
+		},
+	}
+	commands = append(commands, jobsCommand)
+}
+
+func planDirFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "plan-dir, d",
+			Usage: "Directory containing job plan files",
+			Value: jobs.DefaultPlanDir,
+		},
+	}
+}
+
+func jobsListAction(c *cli.Context) {
+	dir := c.String("plan-dir")
+
+	jobIDs, err := jobs.List(dir)
+	if err != nil {
+		applog.Fail(err)
+	}
+
+	for _, jobID := range jobIDs {
+		plan, err := jobs.Load(dir, jobID)
+		if err != nil {
+			fmt.Printf("%s (unreadable: %s)\n", jobID, err)
+			continue
+		}
+		fmt.Printf("%s %s\n", jobID, planSummary(plan))
+	}
+}
+
+func jobsShowAction(c *cli.Context) {
+	dir := c.String("plan-dir")
+
+	if len(c.Args()) != 1 {
+		applog.Fail(fmt.Errorf("jobs show requires exactly one job ID"))
+	}
+	jobID := c.Args()[0]
+
+	plan, err := jobs.Load(dir, jobID)
+	if err != nil {
+		applog.Fail(err)
+	}
+
+	for _, fp := range plan.Files {
+		status := "staging"
+		if fp.Committed {
+			status = "committed"
+		}
+		fmt.Printf("%s -> %s [%s] fid=%s tier=%s blocks=%d/%d\n",
+			fp.SourcePath, fp.TargetBlob, status, fp.Fid, fp.Tier, len(fp.BlockIDs)-len(fp.PendingBlocks()), len(fp.BlockIDs))
+	}
+}
+
+func jobsCleanAction(c *cli.Context) {
+	dir := c.String("plan-dir")
+
+	jobIDs, err := jobs.List(dir)
+	if err != nil {
+		applog.Fail(err)
+	}
+
+	for _, jobID := range jobIDs {
+		plan, err := jobs.Load(dir, jobID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", jobID, err)
+			continue
+		}
+		if !allCommitted(plan) {
+			continue
+		}
+		if err := jobs.Remove(dir, jobID); err != nil {
+			applog.Fail(err)
+		}
+		fmt.Printf("removed %s\n", jobID)
+	}
+}
+
+func allCommitted(plan *jobs.Plan) bool {
+	for _, fp := range plan.Files {
+		if !fp.Committed {
+			return false
+		}
+	}
+	return true
+}
+
+func planSummary(plan *jobs.Plan) string {
+	total, committed := 0, 0
+	for _, fp := range plan.Files {
+		total++
+		if fp.Committed {
+			committed++
+		}
+	}
+	return fmt.Sprintf("(%d/%d files committed)", committed, total)
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// pacerPolicy adapts a Pacer into an azcore pipeline policy so every request
+// a blob client issues is throttled the same way the old azure-pipeline-go
+// based pipeline did.
+type pacerPolicy struct {
+	pacer Pacer
+}
+
+func (p *pacerPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if p.pacer != nil {
+		size := req.Raw().ContentLength
+		if err := p.pacer.RequestTrafficAllocation(req.Raw().Context(), size); err != nil {
+			return nil, err
+		}
+	}
+	return req.Next()
+}
+
+// logPolicy logs the outcome of every request at LogDebug (errors at
+// LogError), replacing the logging the deprecated pipeline package used to
+// give us for free.
+type logPolicy struct{}
+
+func (logPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if err != nil {
+		Log(LogError, req.Raw().URL.String()+": "+err.Error())
+		return resp, err
+	}
+	Log(LogDebug, req.Raw().Method+" "+req.Raw().URL.String()+": "+resp.Status)
+	return resp, nil
+}
+
+// NewClientOptions builds the azcore client options shared by every blob (or
+// datalake) client the plugin constructs, preserving the retry, pacing and
+// logging behavior of the old util.NewPipeline helper.
+func NewClientOptions(pacer Pacer, telemetryApplicationID string) policy.ClientOptions {
+	return policy.ClientOptions{
+		Telemetry: policy.TelemetryOptions{
+			ApplicationID: telemetryApplicationID,
+		},
+		PerRetryPolicies: []policy.Policy{
+			&pacerPolicy{pacer: pacer},
+			logPolicy{},
+		},
+	}
+}
@@ -0,0 +1,158 @@
+// Package jobs implements azcopy-style resumable plan files for multi-file
+// HSM archive/restore batches: each in-flight file gets a block-level
+// completion bitmap persisted to disk, so a plugin restart or coordinator
+// retry can pick a transfer back up instead of starting it over.
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultPlanDir is used when the plugin config doesn't set PlanDir.
+const DefaultPlanDir = "/var/lib/lemur/jobs"
+
+const planSuffix = ".plan"
+
+// FilePlan tracks one file within a Plan: its source/target and the
+// per-block staging bitmap.
+type FilePlan struct {
+	Fid        string   `json:"fid"`
+	SourcePath string   `json:"source_path"`
+	TargetBlob string   `json:"target_blob"`
+	BlockSize  int64    `json:"block_size"`
+	Tier       string   `json:"tier,omitempty"`
+	BlockIDs   []string `json:"block_ids"`
+	Staged     []bool   `json:"staged"`
+	Committed  bool     `json:"committed"`
+
+	// SourceSize and SourceModTime (source mtime, UnixNano) are recorded
+	// when the plan is created so a later archive call for the same job ID
+	// can tell whether it's resuming this exact upload or the source file
+	// has since changed underneath it - in which case the block IDs this
+	// plan recorded are no longer valid for the new content and the plan
+	// must be restarted rather than reused.
+	SourceSize    int64 `json:"source_size"`
+	SourceModTime int64 `json:"source_mod_time"`
+}
+
+// MatchesSource reports whether fp was built for a source file of this
+// exact size and modification time.
+func (fp *FilePlan) MatchesSource(size, modTime int64) bool {
+	return fp.SourceSize == size && fp.SourceModTime == modTime
+}
+
+// PendingBlocks returns the indices of blocks not yet marked staged.
+func (fp *FilePlan) PendingBlocks() []int {
+	var pending []int
+	for i, done := range fp.Staged {
+		if !done {
+			pending = append(pending, i)
+		}
+	}
+	return pending
+}
+
+// AllStaged reports whether every block in fp has been staged.
+func (fp *FilePlan) AllStaged() bool {
+	for _, done := range fp.Staged {
+		if !done {
+			return false
+		}
+	}
+	return true
+}
+
+// Plan is the persisted unit of work for one HSM batch. Today the plugin
+// only ever puts a single file in a Plan, but the schema carries a slice so
+// a future batch-submission path can group several files into one plan
+// file without a format change.
+type Plan struct {
+	JobID string      `json:"job_id"`
+	Files []*FilePlan `json:"files"`
+
+	dir string
+}
+
+func planPath(dir, jobID string) string {
+	return filepath.Join(dir, jobID+planSuffix)
+}
+
+// New creates an empty, unsaved plan for jobID under dir.
+func New(dir, jobID string) *Plan {
+	return &Plan{JobID: jobID, dir: dir}
+}
+
+// Load reads a previously saved plan for jobID from dir.
+func Load(dir, jobID string) (*Plan, error) {
+	buf, err := os.ReadFile(planPath(dir, jobID))
+	if err != nil {
+		return nil, fmt.Errorf("reading plan %s: %s", jobID, err)
+	}
+
+	var p Plan
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return nil, fmt.Errorf("unmarshaling plan %s: %s", jobID, err)
+	}
+	p.dir = dir
+	return &p, nil
+}
+
+// Save persists p to dir, creating dir if necessary. The write is atomic
+// (write to a temp file, then rename) so a crash mid-save can't leave a
+// half-written plan file behind.
+func (p *Plan) Save() error {
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return fmt.Errorf("creating plan dir %s: %s", p.dir, err)
+	}
+
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling plan %s: %s", p.JobID, err)
+	}
+
+	dest := planPath(p.dir, p.JobID)
+	tmp := dest + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return fmt.Errorf("writing plan %s: %s", p.JobID, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("committing plan %s: %s", p.JobID, err)
+	}
+	return nil
+}
+
+// List returns the job IDs of every plan file under dir, sorted.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading plan dir %s: %s", dir, err)
+	}
+
+	var jobIDs []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), planSuffix) {
+			continue
+		}
+		jobIDs = append(jobIDs, strings.TrimSuffix(e.Name(), planSuffix))
+	}
+	sort.Strings(jobIDs)
+	return jobIDs, nil
+}
+
+// Remove deletes the plan file for jobID from dir. It is not an error for
+// the plan to already be gone.
+func Remove(dir, jobID string) error {
+	err := os.Remove(planPath(dir, jobID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing plan %s: %s", jobID, err)
+	}
+	return nil
+}
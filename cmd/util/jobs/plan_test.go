@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilePlanMatchesSource(t *testing.T) {
+	fp := &FilePlan{SourceSize: 100, SourceModTime: 42}
+
+	if !fp.MatchesSource(100, 42) {
+		t.Error("MatchesSource(100, 42) = false, want true")
+	}
+	if fp.MatchesSource(101, 42) {
+		t.Error("MatchesSource(101, 42) = true, want false (size changed)")
+	}
+	if fp.MatchesSource(100, 43) {
+		t.Error("MatchesSource(100, 43) = true, want false (mod time changed)")
+	}
+}
+
+func TestFilePlanPendingBlocksAndAllStaged(t *testing.T) {
+	fp := &FilePlan{Staged: []bool{true, false, true, false}}
+
+	if got, want := fp.PendingBlocks(), []int{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PendingBlocks() = %v, want %v", got, want)
+	}
+	if fp.AllStaged() {
+		t.Error("AllStaged() = true, want false")
+	}
+
+	fp.Staged = []bool{true, true, true}
+	if len(fp.PendingBlocks()) != 0 {
+		t.Errorf("PendingBlocks() = %v, want empty", fp.PendingBlocks())
+	}
+	if !fp.AllStaged() {
+		t.Error("AllStaged() = false, want true")
+	}
+}
+
+func TestPlanSaveLoadRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	p := New(dir, "job-1")
+	p.Files = []*FilePlan{{
+		Fid:           "fid-1",
+		SourcePath:    "/src/file",
+		TargetBlob:    "file",
+		BlockSize:     1024,
+		BlockIDs:      []string{"block-0000000000", "block-0000000001"},
+		Staged:        []bool{true, false},
+		SourceSize:    2048,
+		SourceModTime: 99,
+	}}
+
+	if err := p.Save(); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	got, err := Load(dir, "job-1")
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if got.JobID != "job-1" || len(got.Files) != 1 {
+		t.Fatalf("Load: got %+v", got)
+	}
+	if !got.Files[0].MatchesSource(2048, 99) {
+		t.Error("loaded plan lost its source size/mod time")
+	}
+	if !reflect.DeepEqual(got.Files[0].PendingBlocks(), []int{1}) {
+		t.Errorf("loaded plan lost its staged bitmap: PendingBlocks() = %v", got.Files[0].PendingBlocks())
+	}
+
+	jobIDs, err := List(dir)
+	if err != nil {
+		t.Fatalf("List: %s", err)
+	}
+	if want := []string{"job-1"}; !reflect.DeepEqual(jobIDs, want) {
+		t.Errorf("List() = %v, want %v", jobIDs, want)
+	}
+
+	if err := Remove(dir, "job-1"); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if _, err := Load(dir, "job-1"); err == nil {
+		t.Error("Load after Remove: want error, got nil")
+	}
+}
+
+func TestRemoveNonexistentPlanIsNotAnError(t *testing.T) {
+	dir := t.TempDir()
+	if err := Remove(dir, "no-such-job"); err != nil {
+		t.Errorf("Remove: %s, want nil", err)
+	}
+}
+
+func TestListOnMissingDirReturnsEmpty(t *testing.T) {
+	jobIDs, err := List(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatalf("List: %s", err)
+	}
+	if len(jobIDs) != 0 {
+		t.Errorf("List() = %v, want empty", jobIDs)
+	}
+}
@@ -0,0 +1,36 @@
+package util
+
+import "log"
+
+// LogLevel mirrors the severity levels the plugin used to get for free from
+// github.com/Azure/azure-pipeline-go/pipeline. Kept local now that the
+// pipeline package is gone so callers don't have to pull it in just for the
+// log level constants.
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogWarning
+	LogInfo
+	LogDebug
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogError:
+		return "ERROR"
+	case LogWarning:
+		return "WARNING"
+	case LogInfo:
+		return "INFO"
+	case LogDebug:
+		return "DEBUG"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Log writes msg to the plugin's log at the given level.
+func Log(level LogLevel, msg string) {
+	log.Printf("[%s] %s", level, msg)
+}
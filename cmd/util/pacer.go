@@ -0,0 +1,10 @@
+package util
+
+import "context"
+
+// Pacer throttles request bandwidth so a single archive/restore job doesn't
+// starve the rest of the fileserver's network budget. Implementations are
+// expected to block until trafficSize bytes may be sent/received.
+type Pacer interface {
+	RequestTrafficAllocation(ctx context.Context, trafficSize int64) error
+}